@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ---------- pluggable audio decoders ----------
+//
+// Decoder abstracts "turn an input file into 16kHz mono PCM float32
+// samples". The ffmpeg backend keeps the original behavior; the wav and
+// flac backends are pure Go and never shell out.
+type Decoder interface {
+	Decode(path string) (pcm []float32, sampleRate int, err error)
+}
+
+// getDecoder resolves the -decoder flag ("auto", "ffmpeg", "wav", "flac")
+// to a concrete Decoder. "auto" picks a pure-Go backend when the
+// extension is recognized and otherwise falls back to ffmpeg.
+func getDecoder(name, path string) (Decoder, error) {
+	switch strings.ToLower(name) {
+	case "", "auto":
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".wav":
+			return wavDecoder{}, nil
+		case ".flac":
+			return flacDecoder{}, nil
+		default:
+			return ffmpegDecoder{}, nil
+		}
+	case "ffmpeg":
+		return ffmpegDecoder{}, nil
+	case "wav":
+		return wavDecoder{}, nil
+	case "flac":
+		return flacDecoder{}, nil
+	default:
+		return nil, fmt.Errorf("unknown -decoder %q (want auto, ffmpeg, wav, or flac)", name)
+	}
+}
+
+// ---------- ffmpeg backend ----------
+
+type ffmpegDecoder struct{}
+
+func (ffmpegDecoder) Decode(path string) ([]float32, int, error) {
+	tmp, sr, err := toMono16k(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer os.Remove(tmp)
+	pcm, err := readWavPCM16(tmp, sr)
+	if err != nil {
+		return nil, 0, err
+	}
+	return pcm, sr, nil
+}
+
+func toMono16k(in string) (string, int, error) {
+	tmp := filepath.Join(os.TempDir(), fmt.Sprintf("cap-%d.wav", time.Now().UnixNano()))
+	cmd := exec.Command("ffmpeg",
+		"-y", "-i", in,
+		"-ac", "1",
+		"-ar", "16000",
+		"-acodec", "pcm_s16le",
+		tmp,
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", 0, fmt.Errorf("ffmpeg: %v\n%s", err, stderr.String())
+	}
+	return tmp, 16000, nil
+}
+
+// read WAV PCM16 -> float32 mono samples
+func readWavPCM16(path string, sampleRate int) ([]float32, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	// super-light WAV reader: skip 44-byte header
+	if _, err := f.Seek(44, io.SeekStart); err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(f)
+	var samples []float32
+	for {
+		b0, err := r.ReadByte()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, err
+		}
+		b1, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		// little-endian int16
+		v := int16(uint16(b0) | uint16(b1)<<8)
+		samples = append(samples, float32(v)/32768.0)
+	}
+	return samples, nil
+}
+
+// ---------- pure-Go WAV backend ----------
+//
+// Unlike readWavPCM16, this walks the actual RIFF chunk layout, so it
+// copes with a LIST/INFO chunk (or other metadata) ahead of "data".
+
+type wavDecoder struct{}
+
+func (wavDecoder) Decode(path string) ([]float32, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var riffHdr [12]byte
+	if _, err := io.ReadFull(r, riffHdr[:]); err != nil {
+		return nil, 0, fmt.Errorf("wav: %w", err)
+	}
+	if string(riffHdr[0:4]) != "RIFF" || string(riffHdr[8:12]) != "WAVE" {
+		return nil, 0, errors.New("wav: not a RIFF/WAVE file")
+	}
+
+	var (
+		numChannels   int
+		sampleRate    int
+		bitsPerSample int
+		haveFmt       bool
+		pcm           []float32
+	)
+
+	for {
+		var chunkID [4]byte
+		if _, err := io.ReadFull(r, chunkID[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, 0, fmt.Errorf("wav: %w", err)
+		}
+		var size uint32
+		if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+			return nil, 0, fmt.Errorf("wav: %w", err)
+		}
+
+		switch string(chunkID[:]) {
+		case "fmt ":
+			body := make([]byte, size)
+			if _, err := io.ReadFull(r, body); err != nil {
+				return nil, 0, fmt.Errorf("wav: fmt chunk: %w", err)
+			}
+			if len(body) < 16 {
+				return nil, 0, errors.New("wav: fmt chunk too short")
+			}
+			numChannels = int(binary.LittleEndian.Uint16(body[2:4]))
+			sampleRate = int(binary.LittleEndian.Uint32(body[4:8]))
+			bitsPerSample = int(binary.LittleEndian.Uint16(body[14:16]))
+			haveFmt = true
+		case "data":
+			if !haveFmt {
+				return nil, 0, errors.New("wav: data chunk before fmt chunk")
+			}
+			if bitsPerSample != 16 {
+				return nil, 0, fmt.Errorf("wav: unsupported bits-per-sample %d (only 16-bit PCM)", bitsPerSample)
+			}
+			body := make([]byte, size)
+			if _, err := io.ReadFull(r, body); err != nil {
+				return nil, 0, fmt.Errorf("wav: data chunk: %w", err)
+			}
+			pcm = decodeInterleavedPCM16(body, numChannels)
+		default:
+			if _, err := io.CopyN(io.Discard, r, int64(size)); err != nil {
+				return nil, 0, fmt.Errorf("wav: skip %q chunk: %w", string(chunkID[:]), err)
+			}
+		}
+		if size%2 == 1 {
+			// chunks are word-aligned; skip the pad byte
+			if _, err := r.Discard(1); err != nil && !errors.Is(err, io.EOF) {
+				return nil, 0, err
+			}
+		}
+	}
+
+	if !haveFmt || pcm == nil {
+		return nil, 0, errors.New("wav: missing fmt or data chunk")
+	}
+	pcm = resampleLinear(pcm, sampleRate, 16000)
+	return pcm, 16000, nil
+}
+
+// decodeInterleavedPCM16 converts little-endian interleaved 16-bit PCM
+// into mono float32 samples in [-1, 1], downmixing channels by average.
+func decodeInterleavedPCM16(body []byte, channels int) []float32 {
+	if channels < 1 {
+		channels = 1
+	}
+	frameBytes := 2 * channels
+	n := len(body) / frameBytes
+	out := make([]float32, n)
+	for i := 0; i < n; i++ {
+		var sum float32
+		for c := 0; c < channels; c++ {
+			off := i*frameBytes + c*2
+			v := int16(uint16(body[off]) | uint16(body[off+1])<<8)
+			sum += float32(v) / 32768.0
+		}
+		out[i] = sum / float32(channels)
+	}
+	return out
+}
+
+// resampleLinear retargets a pure-Go decode to the 16kHz whisper expects.
+// Not as accurate as a polyphase resampler, but good enough for speech.
+func resampleLinear(pcm []float32, srcRate, dstRate int) []float32 {
+	if srcRate == dstRate || len(pcm) == 0 {
+		return pcm
+	}
+	ratio := float64(srcRate) / float64(dstRate)
+	n := int(float64(len(pcm)) / ratio)
+	out := make([]float32, n)
+	for i := 0; i < n; i++ {
+		srcPos := float64(i) * ratio
+		i0 := int(srcPos)
+		i1 := i0 + 1
+		frac := float32(srcPos - float64(i0))
+		if i1 >= len(pcm) {
+			out[i] = pcm[len(pcm)-1]
+			continue
+		}
+		out[i] = pcm[i0]*(1-frac) + pcm[i1]*frac
+	}
+	return out
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/ggerganov/whisper.cpp/bindings/go/pkg/whisper"
+)
+
+// ---------- speaker diarization (self-contained, unsupervised) ----------
+//
+// Segments the PCM into overlapping frames, extracts log-mel energy
+// vectors per frame, drops silence with an energy-based VAD, clusters
+// the remaining frames with agglomerative (average-linkage) clustering
+// over cosine distance, and assigns each whisper segment the speaker
+// label of its overlapping voiced frames. Segments whose frames disagree
+// are split at every speaker-turn boundary so a single cue never mixes
+// two speakers.
+
+const (
+	diarizeFrameSec   = 1.0
+	diarizeHopSec     = 0.5
+	diarizeNumFilters = 26
+	diarizeMergeDist  = 0.35 // cosine distance above which clusters are no longer merged
+
+	// diarizeMaxClusterFrames bounds the clustering problem size: average-
+	// linkage agglomerative clustering recomputes every remaining pair's
+	// distance at each merge, which is cubic in the number of points. Long
+	// recordings are coarsened down to this many "super-frames" (by
+	// averaging feature vectors over consecutive runs of voiced frames)
+	// before clustering, and the result is propagated back to every frame.
+	diarizeMaxClusterFrames = 200
+)
+
+// frame is one analysis window of audio together with its extracted
+// features and (once clustered) its speaker assignment.
+type diarizeFrame struct {
+	start, end float32
+	feat       []float64
+	voiced     bool
+	speaker    int // -1 until clustered, or if dropped as silence
+}
+
+// diarizeSegments labels each whisper segment with a speaker tag
+// ("Speaker 1", "Speaker 2", ...), splitting segments at speaker-turn
+// boundaries where necessary. It returns the (possibly longer) segment
+// slice alongside a parallel slice of speaker labels.
+func diarizeSegments(pcm []float32, sr int, segs []whisper.Segment, minSpeakers, maxSpeakers int) ([]whisper.Segment, []string) {
+	if len(segs) == 0 {
+		return segs, nil
+	}
+
+	frames := diarizeFrames(pcm, sr)
+	diarizeClusterFrames(frames, minSpeakers, maxSpeakers)
+
+	var outSegs []whisper.Segment
+	var outSpeakers []string
+	for _, s := range segs {
+		for _, part := range splitSegmentBySpeaker(s, frames) {
+			outSegs = append(outSegs, part.seg)
+			outSpeakers = append(outSpeakers, speakerLabel(part.speaker))
+		}
+	}
+	return outSegs, outSpeakers
+}
+
+// diarizeFrames slices the PCM into overlapping analysis frames and
+// computes a log-mel feature vector and voiced/silence flag for each.
+func diarizeFrames(pcm []float32, sr int) []diarizeFrame {
+	frameLen := int(diarizeFrameSec * float64(sr))
+	hopLen := int(diarizeHopSec * float64(sr))
+	if frameLen <= 0 || hopLen <= 0 || len(pcm) < frameLen/4 {
+		return nil
+	}
+
+	var frames []diarizeFrame
+	energies := make([]float64, 0)
+	for start := 0; start+frameLen/4 <= len(pcm); start += hopLen {
+		end := start + frameLen
+		if end > len(pcm) {
+			end = len(pcm)
+		}
+		win := pcm[start:end]
+		frames = append(frames, diarizeFrame{
+			start:   float32(start) / float32(sr),
+			end:     float32(end) / float32(sr),
+			feat:    logMelEnergies(win, sr),
+			speaker: -1,
+		})
+		energies = append(energies, rmsEnergy(win))
+		if end == len(pcm) {
+			break
+		}
+	}
+
+	// Adaptive energy-based VAD: voiced frames must clear a floor relative
+	// to the loudest frame in the clip, so the threshold scales with gain.
+	maxE := 0.0
+	for _, e := range energies {
+		if e > maxE {
+			maxE = e
+		}
+	}
+	thresh := maxE * 0.05
+	for i := range frames {
+		frames[i].voiced = energies[i] > thresh
+	}
+	return frames
+}
+
+func rmsEnergy(samples []float32) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, s := range samples {
+		sum += float64(s) * float64(s)
+	}
+	return math.Sqrt(sum / float64(len(samples)))
+}
+
+// logMelEnergies computes a diarizeNumFilters-dimensional log-mel energy
+// vector for a single frame of samples (zero-padded to the next power of
+// two before the FFT).
+func logMelEnergies(samples []float32, sr int) []float64 {
+	n := nextPow2(len(samples))
+	if n < 2 {
+		n = 2
+	}
+	re := make([]float64, n)
+	im := make([]float64, n)
+	for i, s := range samples {
+		// Hamming window to reduce spectral leakage.
+		w := 0.54 - 0.46*math.Cos(2*math.Pi*float64(i)/float64(len(samples)-1))
+		re[i] = float64(s) * w
+	}
+	fft(re, im)
+
+	filters := melFilterbank(sr, n, diarizeNumFilters)
+	bins := n/2 + 1
+	power := make([]float64, bins)
+	for i := 0; i < bins; i++ {
+		power[i] = re[i]*re[i] + im[i]*im[i]
+	}
+
+	out := make([]float64, diarizeNumFilters)
+	for f := 0; f < diarizeNumFilters; f++ {
+		var sum float64
+		for i := 0; i < bins; i++ {
+			sum += power[i] * filters[f][i]
+		}
+		out[f] = math.Log(sum + 1e-10)
+	}
+	return out
+}
+
+func nextPow2(n int) int {
+	p := 1
+	for p < n {
+		p <<= 1
+	}
+	return p
+}
+
+// fft is an in-place iterative radix-2 Cooley-Tukey FFT. len(re) must be a
+// power of two; im must be the same length (zeroed for a real input).
+func fft(re, im []float64) {
+	n := len(re)
+	if n <= 1 {
+		return
+	}
+	// bit-reversal permutation
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			re[i], re[j] = re[j], re[i]
+			im[i], im[j] = im[j], im[i]
+		}
+	}
+	for length := 2; length <= n; length <<= 1 {
+		ang := -2 * math.Pi / float64(length)
+		wr, wi := math.Cos(ang), math.Sin(ang)
+		for start := 0; start < n; start += length {
+			cwr, cwi := 1.0, 0.0
+			half := length / 2
+			for k := 0; k < half; k++ {
+				ur, ui := re[start+k], im[start+k]
+				vr := re[start+k+half]*cwr - im[start+k+half]*cwi
+				vi := re[start+k+half]*cwi + im[start+k+half]*cwr
+				re[start+k] = ur + vr
+				im[start+k] = ui + vi
+				re[start+k+half] = ur - vr
+				im[start+k+half] = ui - vi
+				cwr, cwi = cwr*wr-cwi*wi, cwr*wi+cwi*wr
+			}
+		}
+	}
+}
+
+// melFilterbank builds a triangular mel filterbank over nfft/2+1 FFT bins.
+func melFilterbank(sr, nfft, nfilters int) [][]float64 {
+	hzToMel := func(hz float64) float64 { return 2595 * math.Log10(1+hz/700) }
+	melToHz := func(mel float64) float64 { return 700 * (math.Pow(10, mel/2595) - 1) }
+
+	lowMel, highMel := hzToMel(0), hzToMel(float64(sr)/2)
+	points := make([]float64, nfilters+2)
+	for i := range points {
+		points[i] = lowMel + float64(i)*(highMel-lowMel)/float64(nfilters+1)
+	}
+	bins := make([]int, len(points))
+	for i, m := range points {
+		bins[i] = int(math.Floor((float64(nfft) + 1) * melToHz(m) / float64(sr)))
+	}
+
+	numBins := nfft/2 + 1
+	filters := make([][]float64, nfilters)
+	for f := 0; f < nfilters; f++ {
+		filters[f] = make([]float64, numBins)
+		left, center, right := bins[f], bins[f+1], bins[f+2]
+		for i := left; i < center && i < numBins; i++ {
+			if i >= 0 && center > left {
+				filters[f][i] = float64(i-left) / float64(center-left)
+			}
+		}
+		for i := center; i < right && i < numBins; i++ {
+			if i >= 0 && right > center {
+				filters[f][i] = float64(right-i) / float64(right-center)
+			}
+		}
+	}
+	return filters
+}
+
+// diarizeClusterFrames assigns a cluster (speaker) id to every voiced
+// frame via agglomerative average-linkage clustering over cosine
+// distance. Merging always continues until at most -max-speakers
+// clusters remain, then only while the closest remaining pair of
+// clusters is within diarizeMergeDist, and never goes below
+// -min-speakers clusters. Voiced frames are
+// first coarsened to at most diarizeMaxClusterFrames groups so the
+// clustering cost stays bounded regardless of recording length.
+func diarizeClusterFrames(frames []diarizeFrame, minSpeakers, maxSpeakers int) {
+	voiced := make([]int, 0, len(frames))
+	for i, f := range frames {
+		if f.voiced {
+			voiced = append(voiced, i)
+		}
+	}
+	if len(voiced) == 0 {
+		return
+	}
+
+	members, feats := diarizeGroupFrames(frames, voiced, diarizeMaxClusterFrames)
+
+	clusters := make([][]int, len(feats))
+	for i := range feats {
+		clusters[i] = []int{i}
+	}
+
+	for len(clusters) > minSpeakers {
+		bi, bj, bestDist := -1, -1, math.Inf(1)
+		for i := 0; i < len(clusters); i++ {
+			for j := i + 1; j < len(clusters); j++ {
+				d := avgLinkageDistance(clusters[i], clusters[j], feats)
+				if d < bestDist {
+					bi, bj, bestDist = i, j, d
+				}
+			}
+		}
+		if bi < 0 {
+			break
+		}
+		if len(clusters) <= maxSpeakers && bestDist > diarizeMergeDist {
+			break
+		}
+		clusters[bi] = append(clusters[bi], clusters[bj]...)
+		clusters = append(clusters[:bj], clusters[bj+1:]...)
+	}
+
+	for id, cluster := range clusters {
+		for _, gi := range cluster {
+			for _, frameIdx := range members[gi] {
+				frames[frameIdx].speaker = id
+			}
+		}
+	}
+}
+
+// diarizeGroupFrames coarsens voiced frame indices into at most
+// maxGroups groups, averaging feature vectors over consecutive runs
+// when there are more voiced frames than maxGroups. It returns each
+// group's member frame indices alongside its (possibly averaged)
+// feature vector.
+func diarizeGroupFrames(frames []diarizeFrame, voiced []int, maxGroups int) ([][]int, [][]float64) {
+	if len(voiced) <= maxGroups {
+		members := make([][]int, len(voiced))
+		feats := make([][]float64, len(voiced))
+		for i, idx := range voiced {
+			members[i] = []int{idx}
+			feats[i] = frames[idx].feat
+		}
+		return members, feats
+	}
+
+	groupSize := (len(voiced) + maxGroups - 1) / maxGroups
+	var members [][]int
+	var feats [][]float64
+	for start := 0; start < len(voiced); start += groupSize {
+		end := start + groupSize
+		if end > len(voiced) {
+			end = len(voiced)
+		}
+		idxs := append([]int{}, voiced[start:end]...)
+		members = append(members, idxs)
+		feats = append(feats, averageFeatures(frames, idxs))
+	}
+	return members, feats
+}
+
+func averageFeatures(frames []diarizeFrame, idxs []int) []float64 {
+	dim := len(frames[idxs[0]].feat)
+	out := make([]float64, dim)
+	for _, idx := range idxs {
+		for d := 0; d < dim; d++ {
+			out[d] += frames[idx].feat[d]
+		}
+	}
+	for d := range out {
+		out[d] /= float64(len(idxs))
+	}
+	return out
+}
+
+func avgLinkageDistance(a, b []int, feats [][]float64) float64 {
+	var sum float64
+	for _, i := range a {
+		for _, j := range b {
+			sum += cosineDistance(feats[i], feats[j])
+		}
+	}
+	return sum / float64(len(a)*len(b))
+}
+
+func cosineDistance(a, b []float64) float64 {
+	var dot, na, nb float64
+	for i := range a {
+		dot += a[i] * b[i]
+		na += a[i] * a[i]
+		nb += b[i] * b[i]
+	}
+	if na == 0 || nb == 0 {
+		return 1
+	}
+	sim := dot / (math.Sqrt(na) * math.Sqrt(nb))
+	return 1 - sim
+}
+
+type speakerSpan struct {
+	seg     whisper.Segment
+	speaker int
+}
+
+// splitSegmentBySpeaker labels a whisper segment with the speaker of its
+// overlapping voiced frames, splitting it at every speaker-turn boundary
+// it overlaps so a cue never mixes two speakers; a turn too short to be
+// allotted any words yields no piece. Because we only have segment-level (not
+// word-level) timing here, the split text is divided proportionally to
+// the duration each speaker occupies within the segment; Tokens are
+// re-sliced to match whenever they line up one-to-one with the words
+// (needed so -word-timings still produces correct per-word spans when
+// combined with -diarize), and cleared otherwise.
+func splitSegmentBySpeaker(s whisper.Segment, frames []diarizeFrame) []speakerSpan {
+	type run struct {
+		speaker    int
+		start, end float32
+	}
+	var runs []run
+	for _, f := range frames {
+		if f.speaker < 0 || f.end <= s.Start || f.start >= s.End {
+			continue
+		}
+		if len(runs) > 0 && runs[len(runs)-1].speaker == f.speaker {
+			runs[len(runs)-1].end = f.end
+			continue
+		}
+		runs = append(runs, run{speaker: f.speaker, start: f.start, end: f.end})
+	}
+	if len(runs) == 0 {
+		return []speakerSpan{{seg: s, speaker: -1}}
+	}
+	if len(runs) == 1 {
+		return []speakerSpan{{seg: s, speaker: runs[0].speaker}}
+	}
+
+	words := fieldsPreservePunct(s.Text)
+	tokenSpans := tokenWordSpans(s.Tokens)
+	total := s.End - s.Start
+	var spans []speakerSpan
+	wordStart := 0
+	segStart := s.Start
+	for i, r := range runs {
+		segEnd := r.end
+		if i == len(runs)-1 {
+			segEnd = s.End
+		}
+		share := float64(0)
+		if total > 0 {
+			share = float64(segEnd-segStart) / float64(total)
+		}
+		wordEnd := wordStart + int(math.Round(share*float64(len(words))))
+		if i == len(runs)-1 || wordEnd > len(words) {
+			wordEnd = len(words)
+		}
+		if wordEnd < wordStart {
+			wordEnd = wordStart
+		}
+		text := strings.Join(words[wordStart:wordEnd], " ")
+		if text == "" {
+			segStart = segEnd
+			continue
+		}
+		piece := s
+		piece.Start = segStart
+		piece.End = segEnd
+		piece.Text = text
+		piece.Tokens = sliceTokenWordRanges(s.Tokens, tokenSpans, len(words), [2]int{wordStart, wordEnd})
+		spans = append(spans, speakerSpan{seg: piece, speaker: r.speaker})
+		wordStart = wordEnd
+		segStart = segEnd
+	}
+	if len(spans) == 0 {
+		return []speakerSpan{{seg: s, speaker: runs[0].speaker}}
+	}
+	return spans
+}
+
+func speakerLabel(id int) string {
+	if id < 0 {
+		return ""
+	}
+	return fmt.Sprintf("Speaker %d", id+1)
+}
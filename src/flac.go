@@ -0,0 +1,572 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ---------- pure-Go FLAC backend ----------
+//
+// A minimal but real FLAC decoder: STREAMINFO, and CONSTANT/VERBATIM/
+// FIXED/LPC subframes with Rice-coded (and Rice-escaped) residuals. Seek
+// tables, Vorbis comments, and other optional metadata blocks are skipped.
+
+type flacDecoder struct{}
+
+func (flacDecoder) Decode(path string) ([]float32, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	var magic [4]byte
+	if _, err := io.ReadFull(br, magic[:]); err != nil {
+		return nil, 0, fmt.Errorf("flac: %w", err)
+	}
+	if string(magic[:]) != "fLaC" {
+		return nil, 0, errors.New("flac: missing fLaC marker")
+	}
+
+	var info flacStreamInfo
+	haveInfo := false
+	for {
+		last, blockType, size, err := readFlacMetaHeader(br)
+		if err != nil {
+			return nil, 0, fmt.Errorf("flac: metadata header: %w", err)
+		}
+		body := make([]byte, size)
+		if _, err := io.ReadFull(br, body); err != nil {
+			return nil, 0, fmt.Errorf("flac: metadata block: %w", err)
+		}
+		if blockType == 0 { // STREAMINFO
+			info = parseFlacStreamInfo(body)
+			haveInfo = true
+		}
+		if last {
+			break
+		}
+	}
+	if !haveInfo {
+		return nil, 0, errors.New("flac: missing STREAMINFO block")
+	}
+	if info.bitsPerSample != 16 {
+		return nil, 0, fmt.Errorf("flac: unsupported bit depth %d (only 16-bit supported)", info.bitsPerSample)
+	}
+
+	var mono []float32
+	bitR := newFlacBitReader(br)
+	for {
+		frame, err := decodeFlacFrame(bitR, info)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("flac: frame: %w", err)
+		}
+		mono = append(mono, downmixFlacFrame(frame)...)
+	}
+
+	mono = resampleLinear(mono, info.sampleRate, 16000)
+	return mono, 16000, nil
+}
+
+type flacStreamInfo struct {
+	sampleRate    int
+	channels      int
+	bitsPerSample int
+}
+
+func readFlacMetaHeader(r io.Reader) (last bool, blockType int, size uint32, err error) {
+	var hdr [4]byte
+	if _, err = io.ReadFull(r, hdr[:]); err != nil {
+		return
+	}
+	last = hdr[0]&0x80 != 0
+	blockType = int(hdr[0] & 0x7f)
+	size = uint32(hdr[1])<<16 | uint32(hdr[2])<<8 | uint32(hdr[3])
+	return
+}
+
+func parseFlacStreamInfo(b []byte) flacStreamInfo {
+	// bytes 10..18: sample rate (20 bits), channels-1 (3 bits),
+	// bits-per-sample-1 (5 bits), total samples (36 bits).
+	bits := binary.BigEndian.Uint64(b[10:18])
+	sampleRate := int(bits >> 44)
+	channels := int((bits>>41)&0x7) + 1
+	bitsPerSample := int((bits>>36)&0x1f) + 1
+	return flacStreamInfo{sampleRate: sampleRate, channels: channels, bitsPerSample: bitsPerSample}
+}
+
+// flacBitReader reads FLAC's big-endian (MSB-first) bitstream.
+type flacBitReader struct {
+	r    *bufio.Reader
+	cur  byte
+	nbit uint
+}
+
+func newFlacBitReader(r *bufio.Reader) *flacBitReader { return &flacBitReader{r: r} }
+
+func (b *flacBitReader) readBit() (uint64, error) {
+	if b.nbit == 0 {
+		c, err := b.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		b.cur = c
+		b.nbit = 8
+	}
+	b.nbit--
+	return uint64(b.cur>>b.nbit) & 1, nil
+}
+
+func (b *flacBitReader) readBits(n int) (uint64, error) {
+	var v uint64
+	for i := 0; i < n; i++ {
+		bit, err := b.readBit()
+		if err != nil {
+			return 0, err
+		}
+		v = v<<1 | bit
+	}
+	return v, nil
+}
+
+func (b *flacBitReader) readSigned(n int) (int64, error) {
+	v, err := b.readBits(n)
+	if err != nil {
+		return 0, err
+	}
+	if v&(1<<(uint(n)-1)) != 0 {
+		v -= 1 << uint(n)
+	}
+	return int64(v), nil
+}
+
+// readUnary reads a unary-coded value: N zero bits followed by a 1 bit.
+func (b *flacBitReader) readUnary() (uint64, error) {
+	var n uint64
+	for {
+		bit, err := b.readBit()
+		if err != nil {
+			return 0, err
+		}
+		if bit == 1 {
+			return n, nil
+		}
+		n++
+	}
+}
+
+func (b *flacBitReader) align() {
+	b.nbit = 0
+}
+
+type flacFrame struct {
+	channels [][]int32
+}
+
+func decodeFlacFrame(br *flacBitReader, info flacStreamInfo) (flacFrame, error) {
+	sync, err := br.readBits(14)
+	if err != nil {
+		return flacFrame{}, io.EOF
+	}
+	if sync != 0x3ffe {
+		return flacFrame{}, errors.New("lost frame sync")
+	}
+	if _, err := br.readBits(1); err != nil { // reserved
+		return flacFrame{}, err
+	}
+	if _, err := br.readBits(1); err != nil { // blocking strategy
+		return flacFrame{}, err
+	}
+	blockSizeCode, err := br.readBits(4)
+	if err != nil {
+		return flacFrame{}, err
+	}
+	sampleRateCode, err := br.readBits(4)
+	if err != nil {
+		return flacFrame{}, err
+	}
+	chanAssign, err := br.readBits(4)
+	if err != nil {
+		return flacFrame{}, err
+	}
+	if _, err := br.readBits(3); err != nil { // sample size (ignored; trust STREAMINFO)
+		return flacFrame{}, err
+	}
+	if _, err := br.readBits(1); err != nil { // reserved
+		return flacFrame{}, err
+	}
+
+	// "UTF-8"-style coded frame/sample number: read and discard.
+	if err := skipFlacUTF8(br); err != nil {
+		return flacFrame{}, err
+	}
+
+	blockSize, err := resolveFlacBlockSize(br, blockSizeCode)
+	if err != nil {
+		return flacFrame{}, err
+	}
+	if err := skipFlacSampleRate(br, sampleRateCode); err != nil {
+		return flacFrame{}, err
+	}
+	if _, err := br.readBits(8); err != nil { // header CRC-8
+		return flacFrame{}, err
+	}
+
+	numChannels := info.channels
+	stereoMode := -1 // 0=left/side, 1=right/side, 2=mid/side
+	if chanAssign >= 8 && chanAssign <= 10 {
+		numChannels = 2
+		stereoMode = int(chanAssign - 8)
+	}
+
+	subs := make([][]int32, numChannels)
+	for ch := 0; ch < numChannels; ch++ {
+		bps := info.bitsPerSample
+		if stereoMode == 0 && ch == 1 {
+			bps++ // side channel carries one extra bit
+		} else if stereoMode == 1 && ch == 0 {
+			bps++
+		} else if stereoMode == 2 && ch == 1 {
+			bps++
+		}
+		samples, err := decodeFlacSubframe(br, blockSize, bps)
+		if err != nil {
+			return flacFrame{}, err
+		}
+		subs[ch] = samples
+	}
+	br.align() // footer CRC-16 is byte-aligned; we don't verify it
+	if _, err := br.readBits(16); err != nil {
+		return flacFrame{}, err
+	}
+
+	undoFlacStereoDecorrelation(subs, stereoMode)
+	return flacFrame{channels: subs}, nil
+}
+
+func skipFlacUTF8(br *flacBitReader) error {
+	first, err := br.readBits(8)
+	if err != nil {
+		return err
+	}
+	extra := 0
+	switch {
+	case first&0x80 == 0:
+		extra = 0
+	case first&0xe0 == 0xc0:
+		extra = 1
+	case first&0xf0 == 0xe0:
+		extra = 2
+	case first&0xf8 == 0xf0:
+		extra = 3
+	case first&0xfc == 0xf8:
+		extra = 4
+	case first&0xfe == 0xfc:
+		extra = 5
+	default:
+		return errors.New("invalid UTF-8 coded number")
+	}
+	for i := 0; i < extra; i++ {
+		if _, err := br.readBits(8); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func resolveFlacBlockSize(br *flacBitReader, code uint64) (int, error) {
+	switch {
+	case code == 1:
+		return 192, nil
+	case code >= 2 && code <= 5:
+		return 576 << (code - 2), nil
+	case code == 6:
+		v, err := br.readBits(8)
+		return int(v) + 1, err
+	case code == 7:
+		v, err := br.readBits(16)
+		return int(v) + 1, err
+	case code >= 8 && code <= 15:
+		return 256 << (code - 8), nil
+	default:
+		return 0, fmt.Errorf("invalid block size code %d", code)
+	}
+}
+
+func skipFlacSampleRate(br *flacBitReader, code uint64) error {
+	switch code {
+	case 12:
+		_, err := br.readBits(8)
+		return err
+	case 13, 14:
+		_, err := br.readBits(16)
+		return err
+	default:
+		return nil // fixed value from STREAMINFO / reserved; nothing to read
+	}
+}
+
+func undoFlacStereoDecorrelation(subs [][]int32, mode int) {
+	if mode < 0 || len(subs) != 2 {
+		return
+	}
+	left, right := subs[0], subs[1]
+	switch mode {
+	case 0: // left/side
+		for i := range left {
+			right[i] = left[i] - right[i]
+		}
+	case 1: // right/side
+		for i := range right {
+			left[i] = right[i] + left[i]
+		}
+	case 2: // mid/side
+		for i := range left {
+			mid := left[i]*2 + (right[i] & 1)
+			side := right[i]
+			l := (mid + side) >> 1
+			r := (mid - side) >> 1
+			left[i], right[i] = l, r
+		}
+	}
+}
+
+func decodeFlacSubframe(br *flacBitReader, blockSize, bps int) ([]int32, error) {
+	if _, err := br.readBits(1); err != nil { // padding bit, always 0
+		return nil, err
+	}
+	typ, err := br.readBits(6)
+	if err != nil {
+		return nil, err
+	}
+	wastedBits := 0
+	hasWasted, err := br.readBit()
+	if err != nil {
+		return nil, err
+	}
+	if hasWasted == 1 {
+		extra, err := br.readUnary()
+		if err != nil {
+			return nil, err
+		}
+		wastedBits = int(extra) + 1
+	}
+	effBps := bps - wastedBits
+
+	var out []int32
+	switch {
+	case typ == 0: // CONSTANT
+		v, err := br.readSigned(effBps)
+		if err != nil {
+			return nil, err
+		}
+		out = make([]int32, blockSize)
+		for i := range out {
+			out[i] = int32(v)
+		}
+	case typ == 1: // VERBATIM
+		out = make([]int32, blockSize)
+		for i := range out {
+			v, err := br.readSigned(effBps)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = int32(v)
+		}
+	case typ >= 8 && typ <= 12: // FIXED, order = typ-8
+		order := int(typ - 8)
+		out, err = decodeFlacFixed(br, blockSize, effBps, order)
+		if err != nil {
+			return nil, err
+		}
+	case typ >= 32: // LPC, order = (typ-32)+1
+		order := int(typ-32) + 1
+		out, err = decodeFlacLPC(br, blockSize, effBps, order)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported subframe type %d", typ)
+	}
+
+	if wastedBits > 0 {
+		for i := range out {
+			out[i] <<= uint(wastedBits)
+		}
+	}
+	return out, nil
+}
+
+func decodeFlacFixed(br *flacBitReader, blockSize, bps, order int) ([]int32, error) {
+	out := make([]int32, blockSize)
+	for i := 0; i < order; i++ {
+		v, err := br.readSigned(bps)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = int32(v)
+	}
+	residual, err := decodeFlacResidual(br, blockSize, order)
+	if err != nil {
+		return nil, err
+	}
+	coeffs := map[int][]int32{
+		0: {},
+		1: {1},
+		2: {2, -1},
+		3: {3, -3, 1},
+		4: {4, -6, 4, -1},
+	}[order]
+	for i := order; i < blockSize; i++ {
+		var pred int64
+		for j, c := range coeffs {
+			pred += int64(c) * int64(out[i-1-j])
+		}
+		out[i] = int32(pred + int64(residual[i-order]))
+	}
+	return out, nil
+}
+
+func decodeFlacLPC(br *flacBitReader, blockSize, bps, order int) ([]int32, error) {
+	out := make([]int32, blockSize)
+	for i := 0; i < order; i++ {
+		v, err := br.readSigned(bps)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = int32(v)
+	}
+	precision, err := br.readBits(4)
+	if err != nil {
+		return nil, err
+	}
+	precision++ // stored as precision-1
+	shift, err := br.readSigned(5)
+	if err != nil {
+		return nil, err
+	}
+	coeffs := make([]int64, order)
+	for i := range coeffs {
+		v, err := br.readSigned(int(precision))
+		if err != nil {
+			return nil, err
+		}
+		coeffs[i] = v
+	}
+	residual, err := decodeFlacResidual(br, blockSize, order)
+	if err != nil {
+		return nil, err
+	}
+	for i := order; i < blockSize; i++ {
+		var pred int64
+		for j, c := range coeffs {
+			pred += c * int64(out[i-1-j])
+		}
+		pred >>= uint(shift)
+		out[i] = int32(pred + int64(residual[i-order]))
+	}
+	return out, nil
+}
+
+// decodeFlacResidual reads the Rice-partitioned residual for `blockSize -
+// predictorOrder` samples.
+func decodeFlacResidual(br *flacBitReader, blockSize, predictorOrder int) ([]int32, error) {
+	method, err := br.readBits(2)
+	if err != nil {
+		return nil, err
+	}
+	if method > 1 {
+		return nil, fmt.Errorf("unsupported residual coding method %d", method)
+	}
+	partOrder, err := br.readBits(4)
+	if err != nil {
+		return nil, err
+	}
+	numPartitions := 1 << partOrder
+	samplesPerPartition := blockSize >> partOrder
+
+	riceParamBits := 4
+	escapeParam := uint64(15)
+	if method == 1 {
+		riceParamBits = 5
+		escapeParam = 31
+	}
+
+	out := make([]int32, 0, blockSize-predictorOrder)
+	for p := 0; p < numPartitions; p++ {
+		n := samplesPerPartition
+		if p == 0 {
+			n -= predictorOrder
+		}
+		param, err := br.readBits(riceParamBits)
+		if err != nil {
+			return nil, err
+		}
+		if param == escapeParam {
+			rawBits, err := br.readBits(5)
+			if err != nil {
+				return nil, err
+			}
+			for i := 0; i < n; i++ {
+				v, err := br.readSigned(int(rawBits))
+				if err != nil {
+					return nil, err
+				}
+				out = append(out, int32(v))
+			}
+			continue
+		}
+		for i := 0; i < n; i++ {
+			v, err := readRiceSigned(br, uint(param))
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+		}
+	}
+	return out, nil
+}
+
+func readRiceSigned(br *flacBitReader, k uint) (int32, error) {
+	q, err := br.readUnary()
+	if err != nil {
+		return 0, err
+	}
+	var r uint64
+	if k > 0 {
+		r, err = br.readBits(int(k))
+		if err != nil {
+			return 0, err
+		}
+	}
+	u := q<<k | r
+	// zigzag decode
+	v := int32(u>>1) ^ -int32(u&1)
+	return v, nil
+}
+
+// downmixFlacFrame averages all channels of a decoded frame into mono
+// float32 samples in [-1, 1].
+func downmixFlacFrame(fr flacFrame) []float32 {
+	if len(fr.channels) == 0 {
+		return nil
+	}
+	n := len(fr.channels[0])
+	out := make([]float32, n)
+	for i := 0; i < n; i++ {
+		var sum float32
+		for _, ch := range fr.channels {
+			sum += float32(ch[i]) / 32768.0
+		}
+		out[i] = sum / float32(len(fr.channels))
+	}
+	return out
+}
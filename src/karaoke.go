@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ggerganov/whisper.cpp/bindings/go/pkg/whisper"
+)
+
+// ---------- karaoke-style word timing ----------
+//
+// -word-timings exposes whisper's per-token timestamps as per-word
+// spans via Cue.Words: inline WebVTT timestamp tags
+// (-highlight-format=vtt-tags, the default), a JSON sidecar alongside
+// SRT output, or a full ASS/SSA file with \k karaoke tags
+// (-highlight-format=ass).
+
+// extractWordTimings groups a segment's tokens into words. Whisper's
+// tokenizer marks the start of a new word with a leading space in the
+// decoded token text, so a token without one is a continuation of the
+// previous word (typical for BPE subword splits).
+func extractWordTimings(seg whisper.Segment) []WordTiming {
+	var words []WordTiming
+	var cur strings.Builder
+	var start, end float32
+	open := false
+
+	flush := func() {
+		if !open {
+			return
+		}
+		if w := strings.TrimSpace(cur.String()); w != "" {
+			words = append(words, WordTiming{Word: w, Start: start, End: end})
+		}
+		cur.Reset()
+		open = false
+	}
+
+	for _, t := range seg.Tokens {
+		if isSpecialToken(t.Text) {
+			continue
+		}
+		if !open || strings.HasPrefix(t.Text, " ") {
+			flush()
+			start = t.Start
+			open = true
+		}
+		cur.WriteString(t.Text)
+		end = t.End
+	}
+	flush()
+	return words
+}
+
+// tokenWordSpans groups tokens into words using the same leading-space
+// rule as extractWordTimings, returning each word's [start,end) index
+// range into tokens. Special tokens never start a span; they're folded
+// into whichever word's range they fall inside.
+func tokenWordSpans(tokens []whisper.Token) [][2]int {
+	var spans [][2]int
+	start := -1
+	for i, t := range tokens {
+		if isSpecialToken(t.Text) {
+			continue
+		}
+		if start == -1 || strings.HasPrefix(t.Text, " ") {
+			if start != -1 {
+				spans = append(spans, [2]int{start, i})
+			}
+			start = i
+		}
+	}
+	if start != -1 {
+		spans = append(spans, [2]int{start, len(tokens)})
+	}
+	return spans
+}
+
+// sliceTokenWordRanges returns the tokens covered by the given
+// word-index ranges (each a [lo,hi) pair), using spans to translate word
+// indices to token indices. It returns nil if spans doesn't have exactly
+// wordCount entries, since that means the token-derived word count and
+// the caller's word count have diverged and a range-for-range slice
+// would be meaningless.
+func sliceTokenWordRanges(tokens []whisper.Token, spans [][2]int, wordCount int, ranges ...[2]int) []whisper.Token {
+	if len(spans) != wordCount || wordCount == 0 {
+		return nil
+	}
+	var out []whisper.Token
+	for _, r := range ranges {
+		lo, hi := r[0], r[1]
+		if lo < 0 {
+			lo = 0
+		}
+		if hi > len(spans) {
+			hi = len(spans)
+		}
+		if lo >= hi {
+			continue
+		}
+		out = append(out, tokens[spans[lo][0]:spans[hi-1][1]]...)
+	}
+	return out
+}
+
+func isSpecialToken(text string) bool {
+	t := strings.TrimSpace(text)
+	if t == "" {
+		return true
+	}
+	if strings.HasPrefix(t, "[") && strings.HasSuffix(t, "]") {
+		return true
+	}
+	if strings.HasPrefix(t, "<|") && strings.HasSuffix(t, "|>") {
+		return true
+	}
+	return false
+}
+
+// tagLineWithWordTimings rewrites a wrapped VTT line, inserting a
+// "<hh:mm:ss.mmm>" timestamp tag before every word except the very
+// first word of the cue (whose start already equals the cue's own
+// start time). *wordIdx tracks position across the whole cue, since a
+// cue's words are split across several calls (one per wrapped line).
+func tagLineWithWordTimings(line string, words []WordTiming, wordIdx *int) string {
+	fields := strings.Fields(line)
+	var b strings.Builder
+	for i, f := range fields {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		if *wordIdx > 0 && *wordIdx < len(words) {
+			b.WriteString("<" + tsVTT(words[*wordIdx].Start) + ">")
+		}
+		b.WriteString(f)
+		*wordIdx++
+	}
+	return b.String()
+}
+
+// writeWordsSidecar writes a JSON file (outPath with its extension
+// replaced by ".json") with {idx, words: [{start,end,word}]} per cue, for the SRT + -word-timings case where
+// SRT itself has no tag syntax to carry timing. It is a no-op when
+// writing to stdout, since there is no path to attach a sidecar to.
+func writeWordsSidecar(outPath string, cues []Cue) error {
+	if strings.ToLower(outPath) == "-" {
+		return nil
+	}
+
+	type wordJSON struct {
+		Start float32 `json:"start"`
+		End   float32 `json:"end"`
+		Word  string  `json:"word"`
+	}
+	type cueJSON struct {
+		Idx   int        `json:"idx"`
+		Words []wordJSON `json:"words"`
+	}
+
+	var out []cueJSON
+	for _, c := range cues {
+		if len(c.Words) == 0 {
+			continue
+		}
+		ws := make([]wordJSON, len(c.Words))
+		for i, wd := range c.Words {
+			ws[i] = wordJSON{Start: wd.Start, End: wd.End, Word: wd.Word}
+		}
+		out = append(out, cueJSON{Idx: c.Idx, Words: ws})
+	}
+
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := strings.TrimSuffix(outPath, filepath.Ext(outPath)) + ".json"
+	return os.WriteFile(path, data, 0644)
+}
+
+// ---------- ASS/SSA karaoke output ----------
+
+func writeASS(w io.Writer, cues []Cue) {
+	fmt.Fprintln(w, "[Script Info]")
+	fmt.Fprintln(w, "ScriptType: v4.00+")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "[V4+ Styles]")
+	fmt.Fprintln(w, "Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, Bold, Italic, Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, Encoding")
+	fmt.Fprintln(w, "Style: Default,Arial,48,&H00FFFFFF,&H000000FF,&H00000000,&H00000000,0,0,0,0,100,100,0,0,1,2,0,2,10,10,10,1")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "[Events]")
+	fmt.Fprintln(w, "Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text")
+	for _, c := range cues {
+		fmt.Fprintf(w, "Dialogue: 0,%s,%s,Default,,0,0,0,,%s\n", tsASS(c.Start), tsASS(c.End), karaokeText(c))
+	}
+}
+
+// karaokeText renders a cue as ASS text with \k karaoke tags (duration
+// in centiseconds per word) when word timings are available, or plain
+// wrapped lines joined by ASS's \N line break otherwise.
+func karaokeText(c Cue) string {
+	if len(c.Words) == 0 {
+		return strings.Join(c.Lines, `\N`)
+	}
+	var b strings.Builder
+	if c.Speaker != "" {
+		b.WriteString(c.Speaker + ": ")
+	}
+	for _, wd := range c.Words {
+		cs := int(math.Round(float64(wd.End-wd.Start) * 100))
+		if cs < 1 {
+			cs = 1
+		}
+		fmt.Fprintf(&b, `{\k%d}%s `, cs, wd.Word)
+	}
+	return strings.TrimSpace(b.String())
+}
+
+func tsASS(t float32) string {
+	h := int(t) / 3600
+	m := (int(t) % 3600) / 60
+	s := t - float32(h*3600+m*60)
+	return fmt.Sprintf("%d:%02d:%05.2f", h, m, s)
+}
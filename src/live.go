@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/ggerganov/whisper.cpp/bindings/go/pkg/whisper"
+)
+
+// ---------- live/streaming captioning ----------
+//
+// -live turns the batch tool into an incremental one: captions are
+// produced as audio arrives. Two sources are supported: raw PCM/WAV
+// piped on stdin (-in -), or a WebSocket server (-serve) that a browser
+// can push 16kHz mono PCM frames to. Both feed the same sliding-window
+// transcription loop.
+
+// liveSession holds the state of one incremental transcription stream:
+// the audio accumulated so far, and how much of it has already been
+// finalized into emitted cues.
+type liveSession struct {
+	ctx         *whisper.Context
+	lang        string
+	windowSec   float64
+	stepSec     float64
+	buf         []float32 // all audio seen so far, at 16kHz mono
+	lastStepLen int       // len(buf) at the last transcription run
+	stableUntil float32   // seconds: cues ending before this are final
+	nextIdx     int
+}
+
+func newLiveSession(ctx *whisper.Context, lang string, windowSec, stepSec float64) *liveSession {
+	return &liveSession{ctx: ctx, lang: lang, windowSec: windowSec, stepSec: stepSec, nextIdx: 1}
+}
+
+// push appends newly-arrived samples and, once enough new audio has
+// accumulated, re-runs transcription over the trailing window. It
+// returns any newly-finalized cues followed by at most one partial cue
+// covering the still-unstable tail (partial may be zero-value if there
+// is nothing left to show).
+func (s *liveSession) push(samples []float32) (finals []Cue, partial Cue, havePartial bool) {
+	s.buf = append(s.buf, samples...)
+	stepLen := int(s.stepSec * 16000)
+	if stepLen <= 0 {
+		stepLen = 1
+	}
+	if len(s.buf)-s.lastStepLen < stepLen {
+		return nil, Cue{}, false
+	}
+	s.lastStepLen = len(s.buf)
+
+	winLen := int(s.windowSec * 16000)
+	win := s.buf
+	if len(win) > winLen {
+		win = win[len(win)-winLen:]
+	}
+	bufOffset := float32(len(s.buf))/16000 - float32(len(win))/16000
+
+	segs, err := transcribe(s.ctx, win, s.lang)
+	if err != nil {
+		return nil, Cue{}, false
+	}
+
+	// Everything more than one step behind "now" is considered stable and
+	// won't be revised by the next window.
+	stableCutoff := float32(len(s.buf))/16000 - float32(s.stepSec)
+
+	for _, seg := range segs {
+		seg.Start += bufOffset
+		seg.End += bufOffset
+		if seg.End <= s.stableUntil {
+			continue // already emitted as final
+		}
+		lines := wrapWords(seg.Text, 42, 2)
+		if len(lines) == 0 {
+			continue
+		}
+		cue := Cue{Idx: s.nextIdx, Start: seg.Start, End: seg.End, Lines: lines, RawText: seg.Text}
+		if seg.End <= stableCutoff {
+			s.stableUntil = seg.End
+			s.nextIdx++
+			finals = append(finals, cue)
+		} else if havePartial {
+			// Later unstable segments extend the one partial cue so it
+			// covers the whole unstable tail.
+			partial.End = seg.End
+			partial.RawText += " " + seg.Text
+			partial.Lines = wrapWords(partial.RawText, 42, 2)
+		} else {
+			cue.Partial = true
+			partial = cue
+			havePartial = true
+		}
+	}
+	return finals, partial, havePartial
+}
+
+// runLive dispatches -live to either the WebSocket server or the stdin
+// reader, depending on whether -serve was given.
+func runLive() {
+	ctx, err := loadModel()
+	if err != nil {
+		fail(err)
+	}
+	defer ctx.Close()
+
+	if *serveAddr != "" {
+		if err := runLiveServer(ctx); err != nil {
+			fail(err)
+		}
+		return
+	}
+	if err := runLiveStdin(ctx); err != nil {
+		fail(err)
+	}
+}
+
+// runLiveStdin reads raw PCM16 (or a WAV-wrapped stream) of 16kHz mono
+// audio from stdin and writes VTT cues to stdout as they stabilize.
+func runLiveStdin(ctx *whisper.Context) error {
+	r := bufio.NewReader(os.Stdin)
+	if err := maybeSkipWavHeader(r); err != nil {
+		return fmt.Errorf("live: %w", err)
+	}
+
+	sess := newLiveSession(ctx, *lang, *liveWindow, *liveStep)
+	fmt.Fprintln(os.Stdout, "WEBVTT")
+	fmt.Fprintln(os.Stdout)
+
+	var pending []byte // odd trailing byte from a previous read, carried forward
+	chunk := make([]byte, 4096)
+	for {
+		n, err := r.Read(chunk)
+		if n > 0 {
+			data := chunk[:n]
+			if len(pending) > 0 {
+				data = append(pending, data...)
+				pending = nil
+			}
+			if len(data)%2 == 1 {
+				pending = append(pending, data[len(data)-1])
+				data = data[:len(data)-1]
+			}
+			samples := decodeInterleavedPCM16(data, 1)
+			finals, partial, havePartial := sess.push(samples)
+			for _, c := range finals {
+				writeVTTCue(os.Stdout, c)
+			}
+			if havePartial {
+				writeVTTCue(os.Stdout, partial)
+			}
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// maybeSkipWavHeader peeks at the stream and, if it looks like a RIFF/
+// WAVE file, consumes chunks up to (and including) the "data" chunk
+// header so the remaining bytes are raw interleaved PCM. Raw PCM input
+// (no RIFF magic) is left untouched.
+func maybeSkipWavHeader(r *bufio.Reader) error {
+	head, err := r.Peek(4)
+	if err != nil || string(head) != "RIFF" {
+		return nil
+	}
+	var riffHdr [12]byte
+	if _, err := io.ReadFull(r, riffHdr[:]); err != nil {
+		return err
+	}
+	for {
+		var chHdr [8]byte
+		if _, err := io.ReadFull(r, chHdr[:]); err != nil {
+			return err
+		}
+		id := string(chHdr[0:4])
+		size := binary.LittleEndian.Uint32(chHdr[4:8])
+		if id == "data" {
+			return nil
+		}
+		if _, err := io.CopyN(io.Discard, r, int64(size)); err != nil {
+			return err
+		}
+		if size%2 == 1 {
+			if _, err := r.Discard(1); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// ---------- WebSocket server ----------
+//
+// A hand-rolled RFC 6455 server: no external dependency is pulled in
+// just to accept a handshake and shuttle a handful of binary/text frames.
+
+const wsMagicGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+func runLiveServer(ctx *whisper.Context) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := wsUpgrade(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer conn.Close()
+		serveLiveWS(ctx, conn)
+	})
+	fmt.Fprintf(os.Stderr, "Listening for live captioning on %s\n", *serveAddr)
+	return http.ListenAndServe(*serveAddr, mux)
+}
+
+func wsUpgrade(w http.ResponseWriter, r *http.Request) (net.Conn, error) {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return nil, errors.New("expected websocket upgrade")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return nil, errors.New("missing Sec-WebSocket-Key")
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, errors.New("response writer does not support hijacking")
+	}
+	conn, buf, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	sum := sha1.Sum([]byte(key + wsMagicGUID))
+	accept := base64.StdEncoding.EncodeToString(sum[:])
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + accept + "\r\n\r\n"
+	if _, err := buf.WriteString(resp); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := buf.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+const (
+	wsOpText   = 0x1
+	wsOpBinary = 0x2
+	wsOpClose  = 0x8
+
+	// wsMaxPayload bounds the frame length the server will allocate for.
+	// A client controls the length header directly; without a cap a
+	// malformed or malicious 64-bit length would make us try to allocate
+	// up to 16 exabytes. 16 MiB is generous for a single PCM16 frame.
+	wsMaxPayload = 16 << 20
+)
+
+// serveLiveWS reads binary PCM16 frames from the client and streams
+// back VTT cue text frames as they stabilize.
+func serveLiveWS(ctx *whisper.Context, conn net.Conn) {
+	sess := newLiveSession(ctx, *lang, *liveWindow, *liveStep)
+	r := bufio.NewReader(conn)
+	for {
+		opcode, payload, err := wsReadFrame(r)
+		if err != nil {
+			return
+		}
+		switch opcode {
+		case wsOpClose:
+			return
+		case wsOpBinary:
+			samples := decodeInterleavedPCM16(payload[:len(payload)-len(payload)%2], 1)
+			finals, partial, havePartial := sess.push(samples)
+			for _, c := range finals {
+				wsWriteCue(conn, c)
+			}
+			if havePartial {
+				wsWriteCue(conn, partial)
+			}
+		}
+	}
+}
+
+func wsWriteCue(conn net.Conn, c Cue) {
+	var b strings.Builder
+	writeVTTCue(&b, c)
+	_ = wsWriteFrame(conn, wsOpText, []byte(b.String()))
+}
+
+// wsReadFrame reads a single (client-to-server, therefore masked) WS
+// frame. It only needs to support the frame shapes a browser actually
+// sends: no fragmentation, payload lengths up to wsMaxPayload (longer
+// frames are rejected with an error).
+func wsReadFrame(r *bufio.Reader) (opcode byte, payload []byte, err error) {
+	var hdr [2]byte
+	if _, err = io.ReadFull(r, hdr[:]); err != nil {
+		return
+	}
+	opcode = hdr[0] & 0x0f
+	masked := hdr[1]&0x80 != 0
+	length := uint64(hdr[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(r, ext[:]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(r, ext[:]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > wsMaxPayload {
+		err = fmt.Errorf("frame payload too large: %d bytes", length)
+		return
+	}
+	var maskKey [4]byte
+	if masked {
+		if _, err = io.ReadFull(r, maskKey[:]); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= maskKey[i%4]
+		}
+	}
+	return
+}
+
+// wsWriteFrame writes a single unmasked (server-to-client) WS frame.
+func wsWriteFrame(conn net.Conn, opcode byte, payload []byte) error {
+	var hdr []byte
+	hdr = append(hdr, 0x80|opcode) // FIN=1
+	n := len(payload)
+	switch {
+	case n < 126:
+		hdr = append(hdr, byte(n))
+	case n <= 0xffff:
+		hdr = append(hdr, 126)
+		var ext [2]byte
+		binary.BigEndian.PutUint16(ext[:], uint16(n))
+		hdr = append(hdr, ext[:]...)
+	default:
+		hdr = append(hdr, 127)
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(n))
+		hdr = append(hdr, ext[:]...)
+	}
+	if _, err := conn.Write(hdr); err != nil {
+		return err
+	}
+	_, err := conn.Write(payload)
+	return err
+}
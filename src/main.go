@@ -1,18 +1,13 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
-	"math"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
-	"time"
 
 	"github.com/ggerganov/whisper.cpp/bindings/go/pkg/whisper"
 )
@@ -29,6 +24,26 @@ var (
 	maxLines    = flag.Int("maxlines", 2, "Max lines per cue")
 	format      = flag.String("format", "vtt", "Caption format: vtt | srt (default vtt)")
 	threads     = flag.Int("threads", 0, "Threads (0 = auto)")
+	decoderName = flag.String("decoder", "auto", "Audio decoder: auto | ffmpeg | wav | flac")
+
+	diarize     = flag.Bool("diarize", false, "Label cues with a speaker tag (best-effort, unsupervised)")
+	minSpeakers = flag.Int("min-speakers", 1, "Minimum number of speakers for -diarize")
+	maxSpeakers = flag.Int("max-speakers", 8, "Maximum number of speakers for -diarize")
+
+	live       = flag.Bool("live", false, "Stream captions incrementally instead of processing a whole file")
+	serveAddr  = flag.String("serve", "", "With -live, run a WebSocket server at this address (e.g. :8080) instead of reading stdin")
+	liveWindow = flag.Float64("live-window", 5.0, "Live mode: sliding transcription window, in seconds")
+	liveStep   = flag.Float64("live-step", 1.0, "Live mode: how often to re-run transcription, in seconds")
+
+	wordTimings     = flag.Bool("word-timings", false, "Emit word-level timing for karaoke-style highlighting")
+	highlightFormat = flag.String("highlight-format", "vtt-tags", "Word-highlight style: vtt-tags | ass")
+
+	nospeechThresh = flag.Float64("nospeech-thresh", 0.6, "Drop segments whose no-speech probability is above this")
+	logprobThresh  = flag.Float64("logprob-thresh", -1.0, "Drop segments whose average token logprob is below this")
+	repeatThresh   = flag.Int("repeat-threshold", 4, "Truncate/drop segments where a 3-6 word n-gram repeats more than this many times back-to-back")
+	debugJSON      = flag.String("debug-json", "", "Write a JSON report of segments dropped during overlap reconciliation and hallucination filtering")
+
+	parallelN = flag.Int("parallel", 1, "Transcribe this many chunks concurrently (each worker loads its own whisper context)")
 )
 
 func fail(err error) {
@@ -36,40 +51,52 @@ func fail(err error) {
 	os.Exit(1)
 }
 
+func loadModel() (*whisper.Context, error) {
+	ctx, err := whisper.NewContext(*modelPath)
+	if err != nil {
+		return nil, fmt.Errorf("load model: %w", err)
+	}
+	if *threads > 0 {
+		ctx.SetThreads(*threads)
+	}
+	return ctx, nil
+}
+
 func main() {
 	flag.Parse()
-	if *inPath == "" {
-		fail(errors.New("please provide -in <file>"))
-	}
 	if !strings.EqualFold(*format, "vtt") && !strings.EqualFold(*format, "srt") {
 		fail(errors.New("format must be vtt or srt"))
 	}
 
-	tmpWav, samplerate, err := toMono16k(*inPath)
+	if *live {
+		runLive()
+		return
+	}
+
+	if *inPath == "" {
+		fail(errors.New("please provide -in <file>"))
+	}
+
+	dec, err := getDecoder(*decoderName, *inPath)
 	if err != nil {
 		fail(err)
 	}
-	defer os.Remove(tmpWav)
-
-	pcm, err := readWavPCM16(tmpWav, samplerate)
+	pcm, samplerate, err := dec.Decode(*inPath)
 	if err != nil {
 		fail(err)
 	}
 	if samplerate != 16000 {
-		fail(fmt.Errorf("expected 16k WAV after decode, got %d", samplerate))
+		fail(fmt.Errorf("expected 16k PCM after decode, got %d", samplerate))
 	}
 
-	ctx, err := whisper.NewContext(*modelPath)
+	ctx, err := loadModel()
 	if err != nil {
-		fail(fmt.Errorf("load model: %w", err))
+		fail(err)
 	}
 	defer ctx.Close()
 
-	if *threads > 0 {
-		ctx.SetThreads(*threads)
-	}
-
 	segments := []whisper.Segment{}
+	overlap := float32(0)
 
 	if *windowSec <= 0 {
 		segs, err := transcribe(ctx, pcm, *lang)
@@ -83,28 +110,38 @@ func main() {
 		if win <= ovl {
 			fail(errors.New("window must be > overlap"))
 		}
+		overlap = float32(ovl)
 		chunks := chunkPCM(pcm, 16000, win, ovl)
-		offset := float32(0)
-		for i, c := range chunks {
-			segs, err := transcribe(ctx, c, *lang)
-			if err != nil {
-				fail(fmt.Errorf("chunk %d: %w", i, err))
-			}
-			// shift timestamps by offset
-			for _, s := range segs {
-				s.Start += offset
-				s.End += offset
-				segments = append(segments, s)
-			}
-			// advance offset by window - overlap
-			offset += float32(win - ovl)
+		segs, err := transcribeChunksParallel(ctx, chunks, *lang, *parallelN, win, ovl)
+		if err != nil {
+			fail(err)
+		}
+		segments = segs
+	}
+
+	// Reconcile overlap-zone duplicates, drop low-confidence/hallucinated
+	// segments, and (optionally) record what got dropped and why.
+	report := &debugReport{}
+	segments = reconcileSegments(segments, overlap, *nospeechThresh, *logprobThresh, *repeatThresh, report)
+	if *debugJSON != "" {
+		if err := report.writeTo(*debugJSON); err != nil {
+			fmt.Fprintln(os.Stderr, "warning: -debug-json:", err)
+		}
+	}
+
+	var speakers []string
+	if *diarize {
+		if *minSpeakers < 1 {
+			fail(errors.New("-min-speakers must be >= 1"))
+		}
+		if *maxSpeakers < *minSpeakers {
+			fail(errors.New("-max-speakers must be >= -min-speakers"))
 		}
-		// de-duplicate potential overlap text:
-		segments = dedupeOverlap(segments, float32(*overlapSec))
+		segments, speakers = diarizeSegments(pcm, 16000, segments, *minSpeakers, *maxSpeakers)
 	}
 
 	// Convert segments -> cues with neat wrapping
-	cues := segmentsToCues(segments, *maxChars, *maxLines)
+	cues := segmentsToCues(segments, speakers, *maxChars, *maxLines, *wordTimings)
 
 	// Write output
 	var out *os.File
@@ -120,68 +157,24 @@ func main() {
 		out = f
 	}
 
-	switch strings.ToLower(*format) {
-	case "vtt":
+	switch {
+	case *wordTimings && strings.EqualFold(*highlightFormat, "ass"):
+		writeASS(out, cues)
+	case strings.EqualFold(*format, "vtt"):
 		if !strings.HasSuffix(strings.ToLower(*outPath), ".vtt") && *outPath != "-" {
 			fmt.Fprintln(os.Stderr, "note: writing VTT; consider using .vtt extension")
 		}
 		writeVTT(out, cues)
 	default:
 		writeSRT(out, cues)
-	}
-
-	fmt.Fprintf(os.Stderr, "Wrote %d cues to %s\n", len(cues), *outPath)
-}
-
-// ---------- decoding (ffmpeg -> mono 16k WAV) ----------
-
-func toMono16k(in string) (string, int, error) {
-	tmp := filepath.Join(os.TempDir(), fmt.Sprintf("cap-%d.wav", time.Now().UnixNano()))
-	cmd := exec.Command("ffmpeg",
-		"-y", "-i", in,
-		"-ac", "1",
-		"-ar", "16000",
-		"-acodec", "pcm_s16le",
-		tmp,
-	)
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
-		return "", 0, fmt.Errorf("ffmpeg: %v\n%s", err, stderr.String())
-	}
-	return tmp, 16000, nil
-}
-
-// read WAV PCM16 -> float32 mono samples
-func readWavPCM16(path string, sampleRate int) ([]float32, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
-	// super-light WAV reader: skip 44-byte header
-	if _, err := f.Seek(44, io.SeekStart); err != nil {
-		return nil, err
-	}
-	r := bufio.NewReader(f)
-	var samples []float32
-	for {
-		b0, err := r.ReadByte()
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				break
+		if *wordTimings {
+			if err := writeWordsSidecar(*outPath, cues); err != nil {
+				fmt.Fprintln(os.Stderr, "warning: word-timings sidecar:", err)
 			}
-			return nil, err
-		}
-		b1, err := r.ReadByte()
-		if err != nil {
-			return nil, err
 		}
-		// little-endian int16
-		v := int16(uint16(b0) | uint16(b1)<<8)
-		samples = append(samples, float32(v)/32768.0)
 	}
-	return samples, nil
+
+	fmt.Fprintf(os.Stderr, "Wrote %d cues to %s\n", len(cues), *outPath)
 }
 
 // ---------- chunking ----------
@@ -206,24 +199,6 @@ func chunkPCM(pcm []float32, sr, windowSec, overlapSec int) [][]float32 {
 	return out
 }
 
-func dedupeOverlap(segs []whisper.Segment, overlap float32) []whisper.Segment {
-	if len(segs) < 2 {
-		return segs
-	}
-	out := []whisper.Segment{segs[0]}
-	for i := 1; i < len(segs); i++ {
-		prev := out[len(out)-1]
-		cur := segs[i]
-		// if same (or near-same) text within small time gap, drop duplicate
-		if strings.TrimSpace(prev.Text) == strings.TrimSpace(cur.Text) &&
-			math.Abs(float64(prev.Start-cur.Start)) < float64(overlap)+0.2 {
-			continue
-		}
-		out = append(out, cur)
-	}
-	return out
-}
-
 // ---------- transcription ----------
 
 func transcribe(ctx *whisper.Context, pcm []float32, language string) ([]whisper.Segment, error) {
@@ -237,6 +212,9 @@ func transcribe(ctx *whisper.Context, pcm []float32, language string) ([]whisper
 	params.SetNoContext(true)
 	params.SetSingleSegment(false)
 	params.SetSuppressNonSpeechTokens(true)
+	// Per-token timestamps are what -word-timings/-highlight-format=ass
+	// actually read off Segment.Tokens; without this they come back zeroed.
+	params.SetTokenTimestamps(*wordTimings)
 	if err := ctx.Process(pcm, &params, nil, nil); err != nil {
 		return nil, err
 	}
@@ -255,28 +233,49 @@ func transcribe(ctx *whisper.Context, pcm []float32, language string) ([]whisper
 // ---------- cue building & formatting ----------
 
 type Cue struct {
-	Idx       int
-	Start     float32
-	End       float32
-	Lines     []string
-	RawText   string
+	Idx     int
+	Start   float32
+	End     float32
+	Lines   []string
+	RawText string
+	Speaker string
+	Partial bool
+	Words   []WordTiming
+}
+
+// WordTiming is one word's span within a cue, used for karaoke-style
+// word highlighting (see -word-timings).
+type WordTiming struct {
+	Word  string
+	Start float32
+	End   float32
 }
 
-func segmentsToCues(segs []whisper.Segment, maxChars, maxLines int) []Cue {
+func segmentsToCues(segs []whisper.Segment, speakers []string, maxChars, maxLines int, withWordTimings bool) []Cue {
 	var cues []Cue
 	idx := 1
-	for _, s := range segs {
+	for i, s := range segs {
 		// basic word-safe wrap
 		lines := wrapWords(s.Text, maxChars, maxLines)
 		if len(lines) == 0 {
 			continue
 		}
+		var speaker string
+		if i < len(speakers) {
+			speaker = speakers[i]
+		}
+		var words []WordTiming
+		if withWordTimings {
+			words = extractWordTimings(s)
+		}
 		cues = append(cues, Cue{
 			Idx:     idx,
 			Start:   s.Start,
 			End:     s.End,
 			Lines:   lines,
 			RawText: s.Text,
+			Speaker: speaker,
+			Words:   words,
 		})
 		idx++
 	}
@@ -371,14 +370,20 @@ func mergeShortCues(cues []Cue, minDur float64) []Cue {
 			out = append(out, c)
 			continue
 		}
-		// merge into next if combined length still readable
 		next := cues[i+1]
+		if c.Speaker != next.Speaker {
+			out = append(out, c)
+			continue
+		}
+		// merge into next if combined length still readable
 		merged := Cue{
 			Idx:     c.Idx,
 			Start:   c.Start,
 			End:     next.End,
 			Lines:   wrapWords(strings.TrimSpace(c.RawText+" "+next.RawText), 42, 2),
 			RawText: strings.TrimSpace(c.RawText + " " + next.RawText),
+			Speaker: c.Speaker,
+			Words:   append(append([]WordTiming{}, c.Words...), next.Words...),
 		}
 		out = append(out, merged)
 		i++ // skip next
@@ -403,20 +408,44 @@ func tsSRT(t float32) string {
 func writeVTT(w io.Writer, cues []Cue) {
 	fmt.Fprintln(w, "WEBVTT\n")
 	for _, c := range cues {
-		fmt.Fprintf(w, "%s --> %s\n", tsVTT(c.Start), tsVTT(c.End))
-		for _, line := range c.Lines {
-			fmt.Fprintln(w, line)
+		writeVTTCue(w, c)
+	}
+}
+
+// writeVTTCue writes a single cue, including its timing line and an
+// (unofficial but widely tolerated) "partial:true" cue setting used by
+// -live mode to mark cues that may still be rewritten.
+func writeVTTCue(w io.Writer, c Cue) {
+	fmt.Fprintf(w, "%s --> %s", tsVTT(c.Start), tsVTT(c.End))
+	if c.Partial {
+		fmt.Fprint(w, " partial:true")
+	}
+	fmt.Fprintln(w)
+	wordIdx := 0
+	for i, line := range c.Lines {
+		text := line
+		if len(c.Words) > 0 {
+			text = tagLineWithWordTimings(line, c.Words, &wordIdx)
+		}
+		if i == 0 && c.Speaker != "" {
+			fmt.Fprintf(w, "<v %s>%s\n", c.Speaker, text)
+		} else {
+			fmt.Fprintln(w, text)
 		}
-		fmt.Fprintln(w)
 	}
+	fmt.Fprintln(w)
 }
 
 func writeSRT(w io.Writer, cues []Cue) {
 	for _, c := range cues {
 		fmt.Fprintln(w, c.Idx)
 		fmt.Fprintf(w, "%s --> %s\n", tsSRT(c.Start), tsSRT(c.End))
-		for _, line := range c.Lines {
-			fmt.Fprintln(w, line)
+		for i, line := range c.Lines {
+			if i == 0 && c.Speaker != "" {
+				fmt.Fprintf(w, "%s: %s\n", c.Speaker, line)
+			} else {
+				fmt.Fprintln(w, line)
+			}
 		}
 		fmt.Fprintln(w)
 	}
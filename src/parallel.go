@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ggerganov/whisper.cpp/bindings/go/pkg/whisper"
+)
+
+// ---------- parallel chunk transcription ----------
+//
+// transcribeChunksParallel replaces the old sequential loop with a
+// bounded worker pool (-parallel N). Each worker gets its own
+// whisper.Context (the binding has no Clone()); the caller-supplied ctx
+// is reused as worker 0's, and workers take chunks from a shared queue
+// in no fixed assignment. Results are written into a
+// slice indexed by chunk position so segment order stays monotonic
+// regardless of which worker finished first.
+
+type chunkResult struct {
+	segs []whisper.Segment
+	err  error
+}
+
+func transcribeChunksParallel(ctx *whisper.Context, chunks [][]float32, lang string, parallel, windowSec, overlapSec int) ([]whisper.Segment, error) {
+	if parallel < 1 {
+		parallel = 1
+	}
+	if parallel > len(chunks) {
+		parallel = len(chunks)
+	}
+
+	workers := make([]*whisper.Context, parallel)
+	workers[0] = ctx
+	for i := 1; i < parallel; i++ {
+		c, err := loadModel()
+		if err != nil {
+			return nil, fmt.Errorf("parallel worker %d: %w", i, err)
+		}
+		defer c.Close()
+		workers[i] = c
+	}
+
+	results := make([]chunkResult, len(chunks))
+	jobs := make(chan int)
+	progress := newProgressReporter(len(chunks), windowSec, overlapSec)
+
+	var wg sync.WaitGroup
+	for _, worker := range workers {
+		wg.Add(1)
+		go func(workerCtx *whisper.Context) {
+			defer wg.Done()
+			for idx := range jobs {
+				segs, err := transcribe(workerCtx, chunks[idx], lang)
+				results[idx] = chunkResult{segs: segs, err: err}
+				progress.tick()
+			}
+		}(worker)
+	}
+	for i := range chunks {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	progress.finish()
+
+	step := float32(windowSec - overlapSec)
+	offset := float32(0)
+	var segments []whisper.Segment
+	for i, r := range results {
+		if r.err != nil {
+			return nil, fmt.Errorf("chunk %d: %w", i, r.err)
+		}
+		for _, s := range r.segs {
+			s.Start += offset
+			s.End += offset
+			segments = append(segments, s)
+		}
+		offset += step
+	}
+	return segments, nil
+}
+
+// progressReporter prints chunks/sec, ETA, and realtime factor
+// (audio_seconds / wall_seconds) to stderr as chunks complete. It is
+// safe for concurrent use by multiple workers.
+type progressReporter struct {
+	mu          sync.Mutex
+	total       int
+	done        int
+	start       time.Time
+	chunkAudioS float64
+}
+
+func newProgressReporter(total, windowSec, overlapSec int) *progressReporter {
+	return &progressReporter{
+		total:       total,
+		start:       time.Now(),
+		chunkAudioS: float64(windowSec - overlapSec),
+	}
+}
+
+func (p *progressReporter) tick() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done++
+	elapsed := time.Since(p.start).Seconds()
+	if elapsed <= 0 {
+		elapsed = 0.001
+	}
+	rate := float64(p.done) / elapsed
+	eta := 0.0
+	if rate > 0 {
+		eta = float64(p.total-p.done) / rate
+	}
+	rtf := (float64(p.done) * p.chunkAudioS) / elapsed
+	fmt.Fprintf(os.Stderr, "\rchunk %d/%d (%.2f chunks/s, ETA %.1fs, %.2fx realtime)", p.done, p.total, rate, eta, rtf)
+}
+
+func (p *progressReporter) finish() {
+	fmt.Fprintln(os.Stderr)
+}
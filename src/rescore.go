@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/ggerganov/whisper.cpp/bindings/go/pkg/whisper"
+)
+
+// ---------- confidence-weighted overlap reconciliation & hallucination filter ----------
+//
+// Replaces the old exact-text dedupeOverlap with three passes: drop
+// segments whisper itself flagged as unreliable (no_speech_prob/
+// avg_logprob), truncate/drop hallucination-loop repetition, then in the
+// overlap zone between consecutive chunks keep whichever of two
+// competing segments whisper was more confident about.
+
+type dropRecord struct {
+	Reason string  `json:"reason"`
+	Start  float32 `json:"start"`
+	End    float32 `json:"end"`
+	Text   string  `json:"text"`
+}
+
+type debugReport struct {
+	Dropped []dropRecord `json:"dropped"`
+}
+
+func (r *debugReport) drop(reason string, s whisper.Segment) {
+	if r == nil {
+		return
+	}
+	r.Dropped = append(r.Dropped, dropRecord{Reason: reason, Start: s.Start, End: s.End, Text: s.Text})
+}
+
+func (r *debugReport) writeTo(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// reconcileSegments runs the three passes described above and returns
+// the surviving, time-ordered segments.
+func reconcileSegments(segs []whisper.Segment, overlap float32, nospeechThresh, logprobThresh float64, repeatThresh int, report *debugReport) []whisper.Segment {
+	filtered := make([]whisper.Segment, 0, len(segs))
+	for _, s := range segs {
+		if float64(s.NoSpeechProb) > nospeechThresh {
+			report.drop("no_speech_prob", s)
+			continue
+		}
+		if float64(s.AvgLogProb) < logprobThresh {
+			report.drop("avg_logprob", s)
+			continue
+		}
+		text, tokens, changed := dropRepeatedNgrams(s.Text, s.Tokens, repeatThresh)
+		if strings.TrimSpace(text) == "" {
+			report.drop("repetition", s)
+			continue
+		}
+		if changed {
+			s.Text = text
+			s.Tokens = tokens
+		}
+		filtered = append(filtered, s)
+	}
+
+	if len(filtered) < 2 {
+		return filtered
+	}
+	out := []whisper.Segment{filtered[0]}
+	for i := 1; i < len(filtered); i++ {
+		prev := out[len(out)-1]
+		cur := filtered[i]
+		if !segmentsConflict(prev, cur, overlap) {
+			out = append(out, cur)
+			continue
+		}
+		if segmentConfidence(cur) > segmentConfidence(prev) {
+			report.drop("overlap_loser", prev)
+			out[len(out)-1] = cur
+		} else {
+			report.drop("overlap_loser", cur)
+		}
+	}
+	return out
+}
+
+// segmentsConflict reports whether two time-adjacent segments are
+// competing descriptions of the same overlap-zone audio: either their
+// time ranges intersect by more than 50ms, or they carry identical
+// (whitespace-trimmed) text close together in time (the original dedupe
+// heuristic).
+func segmentsConflict(a, b whisper.Segment, overlap float32) bool {
+	if b.Start < a.End-0.05 {
+		return true
+	}
+	if strings.TrimSpace(a.Text) == strings.TrimSpace(b.Text) &&
+		math.Abs(float64(a.Start-b.Start)) < float64(overlap)+0.2 {
+		return true
+	}
+	return false
+}
+
+// segmentConfidence estimates how much to trust a segment: the mean
+// per-token probability when token-level data is available, falling
+// back to exp(avg_logprob) (whisper's own segment-level confidence
+// proxy) otherwise.
+func segmentConfidence(s whisper.Segment) float32 {
+	var sum float32
+	n := 0
+	for _, t := range s.Tokens {
+		if isSpecialToken(t.Text) {
+			continue
+		}
+		sum += t.P
+		n++
+	}
+	if n > 0 {
+		return sum / float32(n)
+	}
+	return float32(math.Exp(float64(s.AvgLogProb)))
+}
+
+// dropRepeatedNgrams looks for a 3..6 word n-gram that repeats more
+// than repeatThresh times back-to-back (a common whisper failure mode
+// on music/silence) and truncates the run down to a single instance. It
+// returns the possibly-shortened text and tokens (re-sliced to match,
+// when tokens line up one-to-one with words; cleared otherwise) and
+// whether it changed anything. A segment that is nothing but the
+// repeating n-gram - no prefix, no suffix - comes back empty so the
+// caller drops it.
+func dropRepeatedNgrams(text string, tokens []whisper.Token, repeatThresh int) (string, []whisper.Token, bool) {
+	words := strings.Fields(text)
+	spans := tokenWordSpans(tokens)
+	for n := 3; n <= 6; n++ {
+		if len(words) < n*2 {
+			continue
+		}
+		for start := 0; start+n*2 <= len(words); start++ {
+			gram := strings.Join(words[start:start+n], " ")
+			pos := start + n
+			repeats := 1
+			for pos+n <= len(words) && strings.Join(words[pos:pos+n], " ") == gram {
+				repeats++
+				pos += n
+			}
+			if repeats <= repeatThresh {
+				continue
+			}
+			if start == 0 && pos >= len(words) {
+				// The entire segment is this one repeating n-gram: there
+				// is no real content left to keep.
+				return "", nil, true
+			}
+			kept := append(append([]string{}, words[:start+n]...), words[pos:]...)
+			newText := strings.Join(kept, " ")
+			newTokens := sliceTokenWordRanges(tokens, spans, len(words), [2]int{0, start + n}, [2]int{pos, len(words)})
+			return newText, newTokens, newText != text
+		}
+	}
+	return text, tokens, false
+}